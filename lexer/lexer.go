@@ -11,24 +11,91 @@ import (
 
 var eof = rune(-1)
 
+// Options configures optional lexer behaviour. The zero value gives the
+// default lexer returned by New.
+type Options struct {
+	// EmitNewlines makes the lexer return a token.Newline for every line
+	// break instead of skipping it as whitespace, so a parser can use line
+	// boundaries to insert missing semicolons.
+	EmitNewlines bool
+
+	// MaxTokenLen caps the length in bytes of identifiers, numbers,
+	// strings and preserved comments. A longer token is skipped and
+	// reported as token.Illegal rather than buffered. Zero means no limit.
+	MaxTokenLen int
+
+	// PreserveComments keeps comments as trivia: each one is attached,
+	// delimiters included, to the Comments of the token that follows it.
+	// Comments at the end of the input go on the token.EOF token.
+	PreserveComments bool
+}
+
 type Lexer struct {
-	s  *scanner.Scanner
-	ch rune
+	s    *scanner.Scanner
+	ch   rune
+	opts Options
+	done bool
+
+	comments []string
+	// newline is set when a multi-line comment ended in an error, so its
+	// line break is still returned after the illegal token.
+	newline bool
 }
 
 func New(input string) *Lexer {
+	return NewWithOptions(input, Options{})
+}
+
+func NewWithOptions(input string, opts Options) *Lexer {
 	s := &scanner.Scanner{}
 	s.Init(strings.NewReader(input))
 	l := &Lexer{
-		s: s,
+		s:    s,
+		opts: opts,
 	}
 	l.consume()
+	l.skipShebang()
 	return l
 }
 
+// Next returns the next token and true, or false once the token.EOF token
+// has already been returned. It lets callers pull tokens lazily.
+func (l *Lexer) Next() (token.Token, bool) {
+	if l.done {
+		return l.makeToken(token.EOF, ""), false
+	}
+	tok := l.NextToken()
+	if tok.Type == token.EOF {
+		l.done = true
+	}
+	return tok, true
+}
+
+// Lexeme returns all the remaining tokens, ending with token.EOF. Once Next
+// has already returned token.EOF there are none left and it returns nil.
+func (l *Lexer) Lexeme() []token.Token {
+	var tokens []token.Token
+	for tok, ok := l.Next(); ok; tok, ok = l.Next() {
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
+
 func (l *Lexer) NextToken() token.Token {
 
-	l.skipWhitespaces()
+	if l.newline {
+		l.newline = false
+		return l.makeToken(token.Newline, "\n")
+	}
+
+	newline, err := l.skipWhitespacesAndComments()
+	if err != nil {
+		return l.makeToken(token.Illegal, err.Error())
+	}
+	if newline {
+		// l.ch is already past the comment that held the line break.
+		return l.makeToken(token.Newline, "\n")
+	}
 
 	var tok token.Token
 	switch l.ch {
@@ -46,7 +113,7 @@ func (l *Lexer) NextToken() token.Token {
 		}
 	case '>':
 		if l.match('=') {
-			tok = l.makeToken(token.GreaterThanEqual, string(l.ch))
+			tok = l.makeToken(token.GreaterThanEqual, ">=")
 		} else {
 			tok = l.makeToken(token.GreaterThan, string(l.ch))
 		}
@@ -58,11 +125,17 @@ func (l *Lexer) NextToken() token.Token {
 		}
 
 	case '*':
-		tok = l.makeToken(token.Asterisk, string(l.ch))
+		if l.match('*') {
+			tok = l.makeToken(token.Power, "**")
+		} else {
+			tok = l.makeToken(token.Asterisk, string(l.ch))
+		}
 	case '/':
 		tok = l.makeToken(token.Slash, string(l.ch))
 	case ',':
 		tok = l.makeToken(token.Comma, string(l.ch))
+	case ':':
+		tok = l.makeToken(token.Colon, string(l.ch))
 	case ';':
 		tok = l.makeToken(token.Semicolon, string(l.ch))
 	case '.':
@@ -83,26 +156,41 @@ func (l *Lexer) NextToken() token.Token {
 		tok = l.makeToken(token.Plus, string(l.ch))
 	case '-':
 		tok = l.makeToken(token.Minus, string(l.ch))
+	case '~':
+		tok = l.makeToken(token.Tilde, string(l.ch))
 	case '"':
+		// readString leaves l.ch past the closing quote.
 		literal, err := l.readString()
 		if err != nil {
-			tok = l.makeToken(token.Illegal, err.Error())
-		} else {
-			tok = l.makeToken(token.String, literal)
+			return l.makeToken(token.Illegal, err.Error())
+		}
+		return l.makeToken(token.String, literal)
+	case '\'':
+		// readChar leaves l.ch past the closing quote.
+		literal, err := l.readChar()
+		if err != nil {
+			return l.makeToken(token.Illegal, err.Error())
 		}
+		return l.makeToken(token.Char, literal)
+	case '\n':
+		tok = l.makeToken(token.Newline, string(l.ch))
 	case eof:
 		tok = l.makeToken(token.EOF, "")
 	default:
+		// readIdentifier and readNumber leave l.ch on the first rune after
+		// the token, so it must not be consumed again.
 		if unicode.IsLetter(l.ch) {
-			literal := l.readIdentifier()
-			tok = l.makeToken(token.LookupIdentifier(literal), literal)
+			literal, err := l.readIdentifier()
+			if err != nil {
+				return l.makeToken(token.Illegal, err.Error())
+			}
+			return l.makeToken(token.LookupIdentifier(literal), literal)
 		} else if unicode.IsNumber(l.ch) {
 			literal, err := l.readNumber()
 			if err != nil {
-				tok = l.makeToken(token.Illegal, err.Error())
-			} else {
-				tok = l.makeToken(token.Number, literal)
+				return l.makeToken(token.Illegal, err.Error())
 			}
+			return l.makeToken(token.Number, literal)
 		} else {
 			tok = l.makeToken(token.Illegal, fmt.Sprintf("unknown token: %s", string(l.ch)))
 		}
@@ -134,15 +222,144 @@ func (l *Lexer) isAtEnd() bool {
 	return l.ch == eof
 }
 
-func (l *Lexer) skipWhitespaces() {
-	for unicode.IsSpace(l.ch) {
+// skipWhitespacesAndComments reports whether, in EmitNewlines mode, it
+// skipped a block comment spanning lines. Such a comment counts as a
+// single line break, as in Go, even when it is reported as too long.
+func (l *Lexer) skipWhitespacesAndComments() (bool, error) {
+	for {
+		switch {
+		case l.ch == '\n' && l.opts.EmitNewlines:
+			return false, nil
+		case unicode.IsSpace(l.ch):
+			l.consume()
+		case l.ch == '/' && l.peek() == '/':
+			comment, err := l.readLineComment()
+			if err != nil {
+				return false, err
+			}
+			l.addComment(comment)
+		case l.ch == '/' && l.peek() == '*':
+			comment, multiline, err := l.readBlockComment()
+			newline := multiline && l.opts.EmitNewlines
+			if err != nil {
+				l.newline = newline
+				return false, err
+			}
+			l.addComment(comment)
+			if newline {
+				return true, nil
+			}
+		default:
+			return false, nil
+		}
+	}
+}
+
+// readLineComment reads a "//" comment up to, but not including, the end
+// of the line. Its text is only kept when comments are preserved.
+func (l *Lexer) readLineComment() (string, error) {
+	if !l.opts.PreserveComments {
+		l.skipLineComment()
+		return "", nil
+	}
+
+	strBuilder := &strings.Builder{}
+	for l.ch != '\n' && !l.isAtEnd() {
+		strBuilder.WriteRune(l.ch)
+		if err := l.checkLength(strBuilder); err != nil {
+			l.skipLineComment()
+			return "", err
+		}
 		l.consume()
 	}
+	return strBuilder.String(), nil
 }
 
-func (l *Lexer) match(ch rune) bool {
+func (l *Lexer) skipLineComment() {
+	for l.ch != '\n' && !l.isAtEnd() {
+		l.consume()
+	}
+}
+
+// readBlockComment reads a "/* */" comment and reports whether it
+// contained a line break. Its text is only kept when comments are
+// preserved.
+func (l *Lexer) readBlockComment() (string, bool, error) {
+	l.consume() // start /
+	l.consume() // start *
+	if !l.opts.PreserveComments {
+		multiline, err := l.skipBlockComment()
+		return "", multiline, err
+	}
+
+	strBuilder := &strings.Builder{}
+	strBuilder.WriteString("/*")
+	multiline := false
+	for !(l.ch == '*' && l.peek() == '/') {
+		if l.isAtEnd() {
+			return "", false, l.makeError("unterminated comment")
+		}
+		if l.ch == '\n' {
+			multiline = true
+		}
+		strBuilder.WriteRune(l.ch)
+		if err := l.checkLength(strBuilder); err != nil {
+			rest, skipErr := l.skipBlockComment()
+			if skipErr != nil {
+				return "", false, skipErr
+			}
+			return "", multiline || rest, err
+		}
+		l.consume()
+	}
+	// end */
+	strBuilder.WriteString("*/")
 	l.consume()
-	if l.isAtEnd() || l.ch != ch {
+	l.consume()
+	return strBuilder.String(), multiline, nil
+}
+
+// skipBlockComment skips the rest of a block comment without keeping its
+// text and reports whether it contained a line break.
+func (l *Lexer) skipBlockComment() (bool, error) {
+	multiline := false
+	for !(l.ch == '*' && l.peek() == '/') {
+		if l.isAtEnd() {
+			return false, l.makeError("unterminated comment")
+		}
+		if l.ch == '\n' {
+			multiline = true
+		}
+		l.consume()
+	}
+	// end */
+	l.consume()
+	l.consume()
+	return multiline, nil
+}
+
+func (l *Lexer) addComment(comment string) {
+	if l.opts.PreserveComments {
+		l.comments = append(l.comments, comment)
+	}
+}
+
+// skipShebang ignores a "#!" interpreter line at the very start of the
+// input so scripts can be made executable. It leaves the line break in
+// place.
+func (l *Lexer) skipShebang() {
+	if l.ch != '#' || l.peek() != '!' {
+		return
+	}
+	for l.ch != '\n' && !l.isAtEnd() {
+		l.consume()
+	}
+}
+
+// match advances onto the next rune only if it is ch, leaving l.ch on the
+// last rune of the token either way.
+func (l *Lexer) match(ch rune) bool {
+	if l.isAtEnd() || l.peek() != ch {
 		return false
 	}
 	l.consume()
@@ -164,6 +381,13 @@ func (l *Lexer) readString() (string, error) {
 		} else {
 			strBuilder.WriteRune(l.ch)
 		}
+		if err := l.checkLength(strBuilder); err != nil {
+			for l.ch != '"' && !l.isAtEnd() {
+				l.consume()
+			}
+			l.consume()
+			return "", err
+		}
 		l.consume()
 	}
 	// end ".
@@ -171,12 +395,96 @@ func (l *Lexer) readString() (string, error) {
 	return strBuilder.String(), nil
 }
 
+func (l *Lexer) readChar() (string, error) {
+	l.consume() // start '.
+
+	var ch rune
+	switch {
+	case l.ch == '\'':
+		l.consume()
+		return "", l.makeError("empty character literal")
+	case l.isAtEnd() || l.ch == '\n':
+		return "", l.makeError("unterminated character literal")
+	case l.ch == '\\':
+		escaped, err := l.readEscape()
+		if err != nil {
+			l.skipCharLiteral()
+			return "", err
+		}
+		ch = escaped
+	default:
+		ch = l.ch
+		l.consume()
+	}
+
+	if l.isAtEnd() || l.ch == '\n' {
+		return "", l.makeError("unterminated character literal")
+	}
+	if l.ch != '\'' {
+		l.skipCharLiteral()
+		return "", l.makeError("character literal must contain a single character")
+	}
+	// end '.
+	l.consume()
+	return string(ch), nil
+}
+
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'\'': '\'',
+	'"':  '"',
+}
+
+func (l *Lexer) readEscape() (rune, error) {
+	l.consume() // \.
+	if l.isAtEnd() || l.ch == '\n' {
+		return 0, l.makeError("unterminated character literal")
+	}
+	if l.ch == 'x' {
+		l.consume()
+		var value rune
+		for i := 0; i < 2; i++ {
+			if l.isAtEnd() || l.ch == '\n' {
+				return 0, l.makeError("unterminated character literal")
+			}
+			digit, ok := hexValue(l.ch)
+			if !ok {
+				return 0, l.makeError("invalid hex escape")
+			}
+			value = value*16 + digit
+			l.consume()
+		}
+		return value, nil
+	}
+
+	ch, ok := escapes[l.ch]
+	if !ok {
+		return 0, l.makeError(fmt.Sprintf("unknown escape sequence: \\%s", string(l.ch)))
+	}
+	l.consume()
+	return ch, nil
+}
+
+// skipCharLiteral moves past the rest of a malformed character literal so
+// lexing can resume after it.
+func (l *Lexer) skipCharLiteral() {
+	for l.ch != '\'' && l.ch != '\n' && !l.isAtEnd() {
+		l.consume()
+	}
+	if l.ch == '\'' {
+		l.consume()
+	}
+}
+
 func (l *Lexer) readNumber() (string, error) {
 
 	strBuilder := &strings.Builder{}
-	for unicode.IsNumber(l.ch) {
-		strBuilder.WriteRune(l.ch)
-		l.consume()
+	if err := l.readDigits(strBuilder); err != nil {
+		return "", err
 	}
 
 	if l.ch == '.' {
@@ -185,29 +493,62 @@ func (l *Lexer) readNumber() (string, error) {
 		}
 		strBuilder.WriteRune(l.ch)
 		l.consume()
-		for unicode.IsNumber(l.ch) {
-			strBuilder.WriteRune(l.ch)
-			l.consume()
+		if err := l.readDigits(strBuilder); err != nil {
+			return "", err
 		}
 	}
 
 	return strBuilder.String(), nil
 }
 
-func (l *Lexer) readIdentifier() string {
+func (l *Lexer) readDigits(strBuilder *strings.Builder) error {
+	for unicode.IsNumber(l.ch) {
+		strBuilder.WriteRune(l.ch)
+		if err := l.checkLength(strBuilder); err != nil {
+			// Skip the rest of the number the way readNumber reads it: a
+			// '.' belongs to it only when a digit follows.
+			for unicode.IsNumber(l.ch) || (l.ch == '.' && unicode.IsNumber(l.peek())) {
+				l.consume()
+			}
+			return err
+		}
+		l.consume()
+	}
+	return nil
+}
+
+func (l *Lexer) readIdentifier() (string, error) {
 	strBuilder := &strings.Builder{}
 	for isAlphaNumeric(l.ch) {
 		strBuilder.WriteRune(l.ch)
+		if err := l.checkLength(strBuilder); err != nil {
+			for isAlphaNumeric(l.ch) {
+				l.consume()
+			}
+			return "", err
+		}
 		l.consume()
 	}
-	return strBuilder.String()
+	return strBuilder.String(), nil
+}
+
+// checkLength reports an error once the token being built grows past
+// Options.MaxTokenLen.
+func (l *Lexer) checkLength(b *strings.Builder) error {
+	if l.opts.MaxTokenLen > 0 && b.Len() > l.opts.MaxTokenLen {
+		return l.makeError(fmt.Sprintf("token exceeds maximum length of %d", l.opts.MaxTokenLen))
+	}
+	return nil
 }
 
 func (l *Lexer) makeToken(ttype token.TokenType, literal string) token.Token {
-	return token.Token{
-		Type:    ttype,
-		Literal: literal,
+	tok := token.Token{
+		Type:     ttype,
+		Literal:  literal,
+		Comments: l.comments,
 	}
+	l.comments = nil
+	return tok
 }
 
 func (l *Lexer) makeError(msg string) error {
@@ -217,3 +558,15 @@ func (l *Lexer) makeError(msg string) error {
 func isAlphaNumeric(ch rune) bool {
 	return unicode.IsLetter(ch) || unicode.IsNumber(ch) || ch == '_'
 }
+
+func hexValue(ch rune) (rune, bool) {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return ch - '0', true
+	case 'a' <= ch && ch <= 'f':
+		return ch - 'a' + 10, true
+	case 'A' <= ch && ch <= 'F':
+		return ch - 'A' + 10, true
+	}
+	return 0, false
+}
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/alkazarix/talang/token"
@@ -13,7 +14,9 @@ func TestSimpleToken(t *testing.T) {
 / * !
 = == !=
 > >=
-< <=`
+< <=
+** * ~
+:`
 	l := New(input)
 	tests := []struct {
 		expectTok     token.TokenType
@@ -41,6 +44,11 @@ func TestSimpleToken(t *testing.T) {
 		{token.GreaterThanEqual, ">="},
 		{token.LessThan, "<"},
 		{token.LessThanEqual, "<="},
+
+		{token.Power, "**"},
+		{token.Asterisk, "*"},
+		{token.Tilde, "~"},
+		{token.Colon, ":"},
 	}
 
 	for i, test := range tests {
@@ -62,6 +70,8 @@ abc 		xyz 		a123 		A_123			X_x_
 and 		class 	else 		false 		fn
 if 			nil 		or 			print     return 	
 super 	this 		true		let       while
+typeof 	do 			throw 	try 			catch
+xor 		in
 `
 	tests := []struct {
 		expectTok     token.TokenType
@@ -91,6 +101,13 @@ super 	this 		true		let       while
 		{token.Let, "let"},
 
 		{token.While, "while"},
+		{token.Typeof, "typeof"},
+		{token.Do, "do"},
+		{token.Throw, "throw"},
+		{token.Try, "try"},
+		{token.Catch, "catch"},
+		{token.Xor, "xor"},
+		{token.In, "in"},
 	}
 	l := New(input)
 
@@ -154,3 +171,399 @@ func TestString(t *testing.T) {
 		}
 	}
 }
+
+func TestEmitNewlines(t *testing.T) {
+	input := `a
+b;
+
+c /*
+*/ d /* inline */ e`
+	tests := []struct {
+		expectTok     token.TokenType
+		expectLiteral string
+	}{
+		{token.Identifier, "a"},
+		{token.Newline, "\n"},
+		{token.Identifier, "b"},
+		{token.Semicolon, ";"},
+		{token.Newline, "\n"},
+		{token.Newline, "\n"},
+		{token.Identifier, "c"},
+		{token.Newline, "\n"},
+		{token.Identifier, "d"},
+		{token.Identifier, "e"},
+		{token.EOF, ""},
+	}
+
+	l := NewWithOptions(input, Options{EmitNewlines: true})
+	for i, test := range tests {
+		tok := l.NextToken()
+		if tok.Type != test.expectTok {
+			t.Fatalf("test [%d]: expected token is %s. got %s", i, test.expectTok, tok.Type)
+		}
+
+		if tok.Literal != test.expectLiteral {
+			t.Fatalf("test [%d]: expected literal is %q. got %q", i, test.expectLiteral, tok.Literal)
+		}
+	}
+
+	l = New(input)
+	for _, expected := range []token.TokenType{
+		token.Identifier, token.Identifier, token.Semicolon, token.Identifier, token.Identifier, token.Identifier, token.EOF,
+	} {
+		tok := l.NextToken()
+		if tok.Type != expected {
+			t.Fatalf("expected token is %s without newline tokens. got %s", expected, tok)
+		}
+	}
+
+	l = NewWithOptions("/* abcdefgh\n */ a", Options{EmitNewlines: true, PreserveComments: true, MaxTokenLen: 4})
+	for i, expected := range []token.TokenType{token.Illegal, token.Newline, token.Identifier, token.EOF} {
+		tok := l.NextToken()
+		if tok.Type != expected {
+			t.Fatalf("test [%d]: expected token is %s after an oversized comment. got %s", i, expected, tok)
+		}
+	}
+}
+
+func TestAdjacentTokens(t *testing.T) {
+	input := `foo(a,"b")>=1;!x**2*3`
+	tests := []struct {
+		expectTok     token.TokenType
+		expectLiteral string
+	}{
+		{token.Identifier, "foo"},
+		{token.LeftParen, "("},
+		{token.Identifier, "a"},
+		{token.Comma, ","},
+		{token.String, "b"},
+		{token.RightParen, ")"},
+		{token.GreaterThanEqual, ">="},
+		{token.Number, "1"},
+		{token.Semicolon, ";"},
+		{token.Bang, "!"},
+		{token.Identifier, "x"},
+		{token.Power, "**"},
+		{token.Number, "2"},
+		{token.Asterisk, "*"},
+		{token.Number, "3"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, test := range tests {
+		tok := l.NextToken()
+		if tok.Type != test.expectTok {
+			t.Fatalf("test [%d]: expected token is %s. got %s", i, test.expectTok, tok.Type)
+		}
+
+		if tok.Literal != test.expectLiteral {
+			t.Fatalf("test [%d]: expected literal is %q. got %q", i, test.expectLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestShebang(t *testing.T) {
+	input := `#!/usr/bin/env talang
+let a = 1;`
+	tests := []token.TokenType{
+		token.Let, token.Identifier, token.Assign, token.Number, token.Semicolon, token.EOF,
+	}
+
+	l := New(input)
+	for i, test := range tests {
+		tok := l.NextToken()
+		if tok.Type != test {
+			t.Fatalf("test [%d]: expected token is %s. got %s", i, test, tok)
+		}
+	}
+
+	l = New(`let a = 1;
+#!/usr/bin/env talang`)
+	for i := 0; i < 5; i++ {
+		l.NextToken()
+	}
+	tok := l.NextToken()
+	if tok.Type != token.Illegal {
+		t.Fatalf("expected shebang after the first line to be illegal. got %s", tok)
+	}
+}
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		stripped string
+	}{
+		{"let a = 1; // set a", "let a = 1;"},
+		{"/* doc */ fn f(){}", "fn f(){}"},
+		{"a /* multi\nline */ + b // end\n- c", "a + b\n- c"},
+		{"a//b", "a"},
+		{"a / b", "a / b"},
+	}
+
+	for i, test := range tests {
+		got := New(test.input).Lexeme()
+		expected := New(test.stripped).Lexeme()
+		if len(got) != len(expected) {
+			t.Fatalf("test [%d]: expected %d tokens. got %d: %v", i, len(expected), len(got), got)
+		}
+
+		for j := range expected {
+			if got[j].Type != expected[j].Type || got[j].Literal != expected[j].Literal {
+				t.Fatalf("test [%d]: expected token [%d] is %v. got %v", i, j, expected[j], got[j])
+			}
+		}
+	}
+
+	tok := New("/* never closed").NextToken()
+	if tok.Type != token.Illegal {
+		t.Fatalf("expected token is illegal for an unterminated comment. got %s", tok)
+	}
+}
+
+func TestChar(t *testing.T) {
+	tests := []string{
+		"a", "\n", "A", "'", "\\", "字",
+	}
+	input := `'a' '\n' '\x41' '\'' '\\' '字'`
+
+	l := New(input)
+	for _, expected := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != token.Char {
+			t.Fatalf("expected token is char. got %s", tok)
+		}
+
+		if tok.Literal != expected {
+			t.Fatalf("expected literal is %q. got %q", expected, tok.Literal)
+		}
+	}
+
+	for _, input := range []string{`'ab' x`, `'' x`, `'\q' x`, `'\x4' x`} {
+		l := New(input)
+		tok := l.NextToken()
+		if tok.Type != token.Illegal {
+			t.Fatalf("%s: expected token is illegal. got %s", input, tok)
+		}
+
+		tok = l.NextToken()
+		if tok.Type != token.Identifier || tok.Literal != "x" {
+			t.Fatalf("%s: expected lexing to resume at x. got %s", input, tok)
+		}
+	}
+
+	for _, input := range []string{"'a", "'a\nx", "'\\", "'\\x", "'\\x4"} {
+		tok := New(input).NextToken()
+		if tok.Type != token.Illegal || !strings.Contains(tok.Literal, "unterminated character literal") {
+			t.Fatalf("%q: expected unterminated character literal. got %s", input, tok)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	input := `
+fn add(a, b) {
+	return a + b; // sum
+}
+print add(1, 2.5) >= "x";`
+	tests := []struct {
+		expectTok     token.TokenType
+		expectLiteral string
+	}{
+		{token.Function, "fn"},
+		{token.Identifier, "add"},
+		{token.LeftParen, "("},
+		{token.Identifier, "a"},
+		{token.Comma, ","},
+		{token.Identifier, "b"},
+		{token.RightParen, ")"},
+		{token.LeftBrace, "{"},
+		{token.Return, "return"},
+		{token.Identifier, "a"},
+		{token.Plus, "+"},
+		{token.Identifier, "b"},
+		{token.Semicolon, ";"},
+		{token.RightBrace, "}"},
+		{token.Print, "print"},
+		{token.Identifier, "add"},
+		{token.LeftParen, "("},
+		{token.Number, "1"},
+		{token.Comma, ","},
+		{token.Number, "2.5"},
+		{token.RightParen, ")"},
+		{token.GreaterThanEqual, ">="},
+		{token.String, "x"},
+		{token.Semicolon, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, test := range tests {
+		tok, ok := l.Next()
+		if !ok {
+			t.Fatalf("test [%d]: expected a token. got end of stream", i)
+		}
+
+		if tok.Type != test.expectTok || tok.Literal != test.expectLiteral {
+			t.Fatalf("test [%d]: expected token is %s %q. got %s %q", i, test.expectTok, test.expectLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	if tok, ok := l.Next(); ok || tok.Type != token.EOF {
+		t.Fatalf("expected drained lexer to return EOF and false. got %s, %t", tok, ok)
+	}
+
+	if tokens := l.Lexeme(); tokens != nil {
+		t.Fatalf("expected drained lexer to return no tokens. got %v", tokens)
+	}
+
+	tokens := New(input).Lexeme()
+	if len(tokens) != len(tests) {
+		t.Fatalf("expected Lexeme to return %d tokens. got %d", len(tests), len(tokens))
+	}
+	for i, test := range tests {
+		if tokens[i].Type != test.expectTok || tokens[i].Literal != test.expectLiteral {
+			t.Fatalf("test [%d]: expected Lexeme token is %s %q. got %s %q", i, test.expectTok, test.expectLiteral, tokens[i].Type, tokens[i].Literal)
+		}
+	}
+}
+
+func TestMaxTokenLen(t *testing.T) {
+	tests := []struct {
+		input   string
+		illegal bool
+	}{
+		{`abcd`, false},
+		{`abcde`, true},
+		{`1234`, false},
+		{`12345`, true},
+		{`12.45`, true},
+		{`12345.67`, true},
+		{`"abcd"`, false},
+		{`"abcde"`, true},
+	}
+
+	for _, test := range tests {
+		l := NewWithOptions(test.input+" x", Options{MaxTokenLen: 4})
+		tok := l.NextToken()
+		if test.illegal && tok.Type != token.Illegal {
+			t.Fatalf("%s: expected token is illegal. got %s", test.input, tok)
+		}
+
+		if !test.illegal && tok.Type == token.Illegal {
+			t.Fatalf("%s: expected token within the limit. got %s", test.input, tok)
+		}
+
+		tok = l.NextToken()
+		if tok.Type != token.Identifier || tok.Literal != "x" {
+			t.Fatalf("%s: expected lexing to resume at x. got %s", test.input, tok)
+		}
+	}
+
+	l := NewWithOptions(`12345.foo`, Options{MaxTokenLen: 4})
+	for i, expected := range []token.TokenType{token.Illegal, token.Dot, token.Identifier, token.EOF} {
+		tok := l.NextToken()
+		if tok.Type != expected {
+			t.Fatalf("test [%d]: expected token is %s after an oversized number. got %s", i, expected, tok)
+		}
+	}
+
+	l = NewWithOptions(`"`+strings.Repeat("a", 1<<16), Options{MaxTokenLen: 4})
+	tok := l.NextToken()
+	if tok.Type != token.Illegal || !strings.Contains(tok.Literal, "maximum length of 4") {
+		t.Fatalf("expected runaway string to hit the length limit. got %s", tok)
+	}
+
+	tok = l.NextToken()
+	if tok.Type != token.EOF {
+		t.Fatalf("expected token is EOF. got %s", tok)
+	}
+}
+
+func TestPreserveComments(t *testing.T) {
+	input := `// the answer
+/* doc */ let a = 42; // trailing
+/** last */`
+	tests := []struct {
+		expectTok      token.TokenType
+		expectComments []string
+	}{
+		{token.Let, []string{"// the answer", "/* doc */"}},
+		{token.Identifier, nil},
+		{token.Assign, nil},
+		{token.Number, nil},
+		{token.Semicolon, nil},
+		{token.EOF, []string{"// trailing", "/** last */"}},
+	}
+
+	l := NewWithOptions(input, Options{PreserveComments: true})
+	for i, test := range tests {
+		tok := l.NextToken()
+		if tok.Type != test.expectTok {
+			t.Fatalf("test [%d]: expected token is %s. got %s", i, test.expectTok, tok.Type)
+		}
+
+		if len(tok.Comments) != len(test.expectComments) {
+			t.Fatalf("test [%d]: expected comments are %q. got %q", i, test.expectComments, tok.Comments)
+		}
+		for j := range test.expectComments {
+			if tok.Comments[j] != test.expectComments[j] {
+				t.Fatalf("test [%d]: expected comments are %q. got %q", i, test.expectComments, tok.Comments)
+			}
+		}
+	}
+
+	for _, tok := range New(input).Lexeme() {
+		if len(tok.Comments) != 0 {
+			t.Fatalf("expected no comments by default. got %q on %s", tok.Comments, tok.Type)
+		}
+	}
+}
+
+func TestCommentsNotBuffered(t *testing.T) {
+	tests := []struct {
+		input func(body string) string
+		opts  Options
+	}{
+		{func(body string) string { return "//" + body + "\nx" }, Options{}},
+		{func(body string) string { return "/*" + body + "*/ x" }, Options{}},
+		{func(body string) string { return "//" + body + "\nx" }, Options{PreserveComments: true, MaxTokenLen: 16}},
+		{func(body string) string { return "/*" + body + "*/ x" }, Options{PreserveComments: true, MaxTokenLen: 16}},
+		{func(body string) string { return "/*" + body }, Options{PreserveComments: true, MaxTokenLen: 16}},
+	}
+
+	// A comment that is not kept, or is cut off by MaxTokenLen, must cost
+	// the same number of allocations whatever its size.
+	for i, test := range tests {
+		short := lexAllocs(test.input(strings.Repeat("a", 256)), test.opts)
+		long := lexAllocs(test.input(strings.Repeat("a", 1<<20)), test.opts)
+		if long > short {
+			t.Fatalf("test [%d]: expected comment not to be buffered. got %v allocations, %v for a short comment", i, long, short)
+		}
+	}
+
+	l := NewWithOptions("// a long comment\nx /* short */ y", Options{PreserveComments: true, MaxTokenLen: 16})
+	tok := l.NextToken()
+	if tok.Type != token.Illegal || !strings.Contains(tok.Literal, "maximum length of 16") {
+		t.Fatalf("expected kept comment to hit the length limit. got %s", tok)
+	}
+
+	tok = l.NextToken()
+	if tok.Type != token.Identifier || tok.Literal != "x" || len(tok.Comments) != 0 {
+		t.Fatalf("expected lexing to resume at x without comments. got %s %q", tok, tok.Comments)
+	}
+
+	tok = l.NextToken()
+	if tok.Type != token.Identifier || len(tok.Comments) != 1 || tok.Comments[0] != "/* short */" {
+		t.Fatalf("expected y to keep the short comment. got %s %q", tok, tok.Comments)
+	}
+}
+
+func lexAllocs(input string, opts Options) float64 {
+	return testing.AllocsPerRun(5, func() {
+		l := NewWithOptions(input, opts)
+		for _, ok := l.Next(); ok; _, ok = l.Next() {
+		}
+	})
+}
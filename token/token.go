@@ -5,28 +5,37 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	// Comments holds the comments that precede the token, in source order.
+	// It is only filled when the lexer is asked to preserve comments.
+	Comments []string
 }
 
 const (
 	Illegal = "Illegal"
 	EOF     = "EOF"
+	Newline = "Newline"
 
 	// Identifiers + Literals
 	Identifier = "Identifier"
 	Number     = "Number"
 	String     = "String"
+	Char       = "Char" // literal is the decoded character, e.g. "A" for '\x41'
 
 	// Operators
 	Assign   = "="
 	Plus     = "+"
 	Minus    = "-"
 	Bang     = "!"
+	Tilde    = "~"
 	Asterisk = "*"
+	Power    = "**"
 	Slash    = "/"
 	Equal    = "=="
 	NotEqual = "!="
 	Or       = "Or"
 	And      = "And"
+	Xor      = "Xor"
+	In       = "In"
 
 	LessThanEqual    = "<="
 	LessThan         = "<"
@@ -35,6 +44,7 @@ const (
 
 	// Delimiters
 	Comma        = ","
+	Colon        = ":"
 	Semicolon    = ";"
 	Dot          = "."
 	LeftParen    = "("
@@ -56,8 +66,13 @@ const (
 	If       = "If"
 	Else     = "Else"
 	While    = "While"
+	Do       = "Do"
+	Throw    = "Throw"
+	Try      = "Try"
+	Catch    = "Catch"
 	Return   = "Return"
 	Print    = "Print"
+	Typeof   = "Typeof"
 )
 
 var keywords = map[string]TokenType{
@@ -72,10 +87,17 @@ var keywords = map[string]TokenType{
 	"if":     If,
 	"else":   Else,
 	"while":  While,
+	"do":     Do,
+	"throw":  Throw,
+	"try":    Try,
+	"catch":  Catch,
 	"return": Return,
 	"print":  Print,
+	"typeof": Typeof,
 	"or":     Or,
 	"and":    And,
+	"xor":    Xor,
+	"in":     In,
 }
 
 func LookupIdentifier(identifier string) TokenType {